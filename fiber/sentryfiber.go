@@ -3,14 +3,18 @@ package sentryfiber
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/gofiber/fiber/v2"
@@ -18,11 +22,31 @@ import (
 )
 
 const valuesKey = "sentry"
+const spanKey = "sentry_span"
+
+// defaultMaxRequestBodyBytes is the fallback for Options.MaxRequestBodyBytes when it is left at 0.
+const defaultMaxRequestBodyBytes = 8 * 1024
 
 type handler struct {
 	repanic         bool
 	waitForDelivery bool
 	timeout         time.Duration
+
+	enableTracing    bool
+	tracesSampleRate float64
+	tracesSampler    func(ctx *fiber.Ctx) float64
+
+	ignoredRoutes       map[string]struct{}
+	ignoredMethods      map[string]struct{}
+	captureRequestBody  bool
+	maxRequestBodyBytes int
+	captureErrors       bool
+
+	trustedProxies []string
+	proxyHeader    string
+
+	randMu sync.Mutex
+	rand   *rand.Rand
 }
 
 type Options struct {
@@ -36,6 +60,42 @@ type Options struct {
 	WaitForDelivery bool
 	// Timeout for the event delivery requests.
 	Timeout time.Duration
+	// EnableTracing enables performance monitoring for incoming requests. When enabled, handle starts a
+	// transaction for every request that isn't ignored, marks it sampled or not according to
+	// TracesSampler/TracesSampleRate, and finishes it once the request has been handled.
+	EnableTracing bool
+	// TracesSampleRate is the fallback sampling rate used for transactions created by this middleware when
+	// TracesSampler is not set. It is only consulted when EnableTracing is true.
+	TracesSampleRate float64
+	// TracesSampler allows fine-grained, per-request control over the sampling rate of the transactions
+	// created by this middleware. It takes precedence over TracesSampleRate when set.
+	TracesSampler func(ctx *fiber.Ctx) float64
+	// IgnoredRoutes skips hub attachment and panic recovery entirely for requests whose path (ctx.Path())
+	// is in this list, letting Fiber's own recover middleware handle them instead. Matching is against the
+	// literal request path, not the route pattern, so parameterized routes (e.g. "/users/:id") must be
+	// listed as the concrete paths you want ignored rather than as patterns.
+	IgnoredRoutes []string
+	// IgnoredMethods skips hub attachment and panic recovery entirely for requests using one of these HTTP
+	// methods.
+	IgnoredMethods []string
+	// CaptureRequestBody enables reading the request body and attaching it to the Sentry scope's request.
+	// It is off by default since bodies may contain sensitive data and reading them consumes the stream.
+	CaptureRequestBody bool
+	// MaxRequestBodyBytes caps how many bytes of the request body are attached to the Sentry scope's request
+	// when CaptureRequestBody is enabled; the body is taken whole from ctx.Request.Body() and then truncated.
+	// Defaults to 8KB when left at 0.
+	MaxRequestBodyBytes int
+	// CaptureErrors configures whether errors returned from ctx.Next() (as opposed to panics) are reported
+	// to Sentry via hub.CaptureException. This is off by default since Fiber error handlers often turn
+	// these into ordinary error responses.
+	CaptureErrors bool
+	// TrustedProxies lists the IPs and CIDR ranges of proxies that are allowed to set ProxyHeader. Requests
+	// arriving from an address outside this list have ProxyHeader ignored, so the client IP and scheme
+	// can't be spoofed by an untrusted upstream.
+	TrustedProxies []string
+	// ProxyHeader is the header consulted for the original client IP when the request comes from a
+	// TrustedProxies address. Defaults to "X-Forwarded-For".
+	ProxyHeader string
 }
 
 // New returns a function that satisfies gin.HandlerFunc interface
@@ -54,14 +114,48 @@ func New(options ...Options) fiber.Handler {
 	if timeout == 0 {
 		timeout = 2 * time.Second
 	}
+
+	maxRequestBodyBytes := opts.MaxRequestBodyBytes
+	if maxRequestBodyBytes == 0 {
+		maxRequestBodyBytes = defaultMaxRequestBodyBytes
+	}
+
+	proxyHeader := opts.ProxyHeader
+	if proxyHeader == "" {
+		proxyHeader = "X-Forwarded-For"
+	}
+
 	return (&handler{
-		repanic:         opts.Repanic,
-		timeout:         timeout,
-		waitForDelivery: opts.WaitForDelivery,
+		repanic:             opts.Repanic,
+		timeout:             timeout,
+		waitForDelivery:     opts.WaitForDelivery,
+		enableTracing:       opts.EnableTracing,
+		tracesSampleRate:    opts.TracesSampleRate,
+		tracesSampler:       opts.TracesSampler,
+		ignoredRoutes:       toSet(opts.IgnoredRoutes),
+		ignoredMethods:      toSet(opts.IgnoredMethods),
+		captureRequestBody:  opts.CaptureRequestBody,
+		maxRequestBodyBytes: maxRequestBodyBytes,
+		captureErrors:       opts.CaptureErrors,
+		trustedProxies:      opts.TrustedProxies,
+		proxyHeader:         proxyHeader,
+		rand:                rand.New(rand.NewSource(time.Now().UnixNano())),
 	}).handle
 }
 
-func convert(ctx *fasthttp.RequestCtx) *http.Request {
+func toSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+func (h *handler) convert(ctx *fasthttp.RequestCtx) *http.Request {
 	defer func() {
 		if err := recover(); err != nil {
 			sentry.Logger.Printf("%v", err)
@@ -72,8 +166,23 @@ func convert(ctx *fasthttp.RequestCtx) *http.Request {
 
 	r.Method = string(ctx.Method())
 	uri := ctx.URI()
+
+	remoteIP, remotePort := splitHostPort(ctx.RemoteAddr().String())
+	trusted := h.isTrustedProxy(remoteIP)
+
+	scheme := string(uri.Scheme())
+	if trusted {
+		if proto := ctx.Request.Header.Peek("X-Forwarded-Proto"); len(proto) > 0 {
+			scheme = string(proto)
+		}
+	}
+
 	// Ignore error.
-	r.URL, _ = url.Parse(fmt.Sprintf("%s://%s%s", uri.Scheme(), uri.Host(), uri.Path()))
+	r.URL, _ = url.Parse(fmt.Sprintf("%s://%s%s", scheme, uri.Host(), uri.Path()))
+
+	if ctx.IsTLS() || scheme == "https" {
+		r.TLS = &tls.ConnectionState{}
+	}
 
 	// Headers
 	r.Header = make(http.Header)
@@ -89,26 +198,274 @@ func convert(ctx *fasthttp.RequestCtx) *http.Request {
 	})
 
 	// Env
-	r.RemoteAddr = ctx.RemoteAddr().String()
+	clientIP := remoteIP
+	if trusted {
+		if fwd := ctx.Request.Header.Peek(h.proxyHeader); len(fwd) > 0 {
+			clientIP = firstForwardedIP(string(fwd))
+		}
+	}
+	r.RemoteAddr = net.JoinHostPort(clientIP, remotePort)
 
 	// QueryString
 	r.URL.RawQuery = string(ctx.URI().QueryString())
 
 	// Body
-	r.Body = ioutil.NopCloser(bytes.NewReader(ctx.Request.Body()))
+	if h.captureRequestBody {
+		body := truncateRequestBody(ctx.Request.Body(), h.maxRequestBodyBytes)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
 
 	return r
 }
 
+// splitHostPort splits a net.Addr-formatted address into host and port, tolerating addresses that don't
+// carry a port (e.g. unix sockets) by returning the whole string as the host.
+func splitHostPort(addr string) (host, port string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, ""
+	}
+	return host, port
+}
+
+// firstForwardedIP returns the left-most (originating client) address from a comma-separated
+// X-Forwarded-For style header value.
+func firstForwardedIP(header string) string {
+	parts := strings.SplitN(header, ",", 2)
+	return strings.TrimSpace(parts[0])
+}
+
+// isTrustedProxy reports whether ip is in the configured TrustedProxies list, either as an exact match
+// or within a CIDR range.
+func (h *handler) isTrustedProxy(ip string) bool {
+	if len(h.trustedProxies) == 0 {
+		return false
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, proxy := range h.trustedProxies {
+		if _, cidr, err := net.ParseCIDR(proxy); err == nil {
+			if cidr.Contains(parsed) {
+				return true
+			}
+			continue
+		}
+		if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncateRequestBody caps body at max bytes. If that cut point lands in the middle of a multi-byte UTF-8
+// sequence, the incomplete tail is dropped so text bodies don't end with a mangled rune. Only the last few
+// bytes at the boundary are ever inspected, so at most utf8.UTFMax-1 bytes are dropped — binary bodies
+// (images, protobuf, gzip, ...) that are not valid UTF-8 to begin with stay close to the requested length
+// rather than being revalidated (and shrunk away) wholesale.
+func truncateRequestBody(body []byte, max int) []byte {
+	if len(body) <= max {
+		return body
+	}
+
+	truncated := body[:max]
+	for i := len(truncated) - 1; i >= 0 && i > len(truncated)-utf8.UTFMax; i-- {
+		b := truncated[i]
+		if b < utf8.RuneSelf {
+			// ASCII byte: can't be part of a split multi-byte sequence.
+			break
+		}
+		if utf8.RuneStart(b) {
+			if r, size := utf8.DecodeRune(truncated[i:]); r == utf8.RuneError && size <= 1 {
+				truncated = truncated[:i]
+			}
+			break
+		}
+	}
+	return truncated
+}
+
+// ignored reports whether requests matching this route/method should bypass sentryfiber entirely. It
+// matches against ctx.Path() rather than ctx.Route().Path: the latter isn't resolved to the matched
+// endpoint until after ctx.Next() returns, and for a middleware mounted with app.Use(...) it reads back as
+// the middleware's own mount point (e.g. "/") instead of the concrete route being requested.
+func (h *handler) ignored(ctx *fiber.Ctx) bool {
+	if _, ok := h.ignoredMethods[string(ctx.Method())]; ok {
+		return true
+	}
+
+	if _, ok := h.ignoredRoutes[ctx.Path()]; ok {
+		return true
+	}
+
+	return false
+}
+
 func (h *handler) handle(ctx *fiber.Ctx) error {
+	if h.ignored(ctx) {
+		return ctx.Next()
+	}
+
 	hub := sentry.GetHubFromContext(ctx.Context())
 	if hub == nil {
 		hub = sentry.CurrentHub().Clone()
 	}
-	hub.Scope().SetRequest(convert(ctx.Context()))
+	r := h.convert(ctx.Context())
+	hub.Scope().SetRequest(r)
 	ctx.Locals(valuesKey, hub)
-	defer h.recoverWithSentry(hub, convert(ctx.Context()))
-	return ctx.Next()
+
+	hub.AddBreadcrumb(&sentry.Breadcrumb{
+		Category: "http",
+		Level:    sentry.LevelInfo,
+		Data: map[string]interface{}{
+			"method":      string(ctx.Method()),
+			"path":        ctx.Path(),
+			"query":       string(ctx.Request().URI().QueryString()),
+			"remote_addr": ctx.IP(),
+			"user_agent":  string(ctx.Request().Header.UserAgent()),
+		},
+	}, nil)
+
+	var span *sentry.Span
+	if h.enableTracing {
+		span = h.startTransaction(ctx, hub, r)
+		if span != nil {
+			ctx.Locals(spanKey, span)
+			ctx.SetUserContext(span.Context())
+		}
+	}
+
+	defer h.recoverWithSentry(hub, r)
+
+	start := time.Now()
+	err := ctx.Next()
+	status := ctx.Response().StatusCode()
+
+	if err != nil || status >= fiber.StatusBadRequest {
+		hub.AddBreadcrumb(&sentry.Breadcrumb{
+			Category: "http",
+			Level:    breadcrumbLevel(status, err),
+			Data: map[string]interface{}{
+				"status_code": status,
+				"elapsed":     time.Since(start).String(),
+			},
+		}, nil)
+	}
+
+	if h.captureErrors && err != nil {
+		hub.CaptureException(err)
+	}
+
+	if span != nil {
+		renameTransaction(span, ctx)
+		h.finishTransaction(span, ctx, err)
+	}
+
+	return err
+}
+
+// breadcrumbLevel picks the breadcrumb severity for a completed request based on its outcome.
+func breadcrumbLevel(status int, err error) sentry.Level {
+	if err != nil || status >= fiber.StatusInternalServerError {
+		return sentry.LevelError
+	}
+	return sentry.LevelWarning
+}
+
+// startTransaction starts a Sentry transaction for the given request, honoring TracesSampler/TracesSampleRate.
+// The transaction is named from the method and path since ctx.Route() still reflects the middleware's own
+// mount point at this point in the request lifecycle; renameTransaction fixes up the name once Fiber has
+// resolved the matched route.
+func (h *handler) startTransaction(ctx *fiber.Ctx, hub *sentry.Hub, r *http.Request) *sentry.Span {
+	options := []sentry.SpanOption{
+		sentry.WithOpName("http.server"),
+		sentry.ContinueFromRequest(r),
+		sentry.WithTransactionSource(sentry.SourceURL),
+	}
+
+	sampleRate := h.tracesSampleRate
+	if h.tracesSampler != nil {
+		sampleRate = h.tracesSampler(ctx)
+	}
+	sampled := sentry.SampledFalse
+	if sampleRate > 0 && sampleRate >= h.sampleFloat() {
+		sampled = sentry.SampledTrue
+	}
+	options = append(options, sentry.WithSpanSampled(sampled))
+
+	name := fmt.Sprintf("%s %s", r.Method, r.URL.Path)
+
+	span := sentry.StartTransaction(
+		sentry.SetHubOnContext(ctx.Context(), hub),
+		name,
+		options...,
+	)
+	hub.Scope().SetSpan(span)
+	return span
+}
+
+// sampleFloat returns a pseudo-random float64 in [0, 1) from a handler-local, seeded source so sampling
+// decisions aren't tied to the unseeded global math/rand source.
+func (h *handler) sampleFloat() float64 {
+	h.randMu.Lock()
+	defer h.randMu.Unlock()
+	return h.rand.Float64()
+}
+
+// renameTransaction updates span's name to the matched route once it's known, which Fiber only resolves
+// after ctx.Next() returns; before that ctx.Route() reflects the middleware's own mount point (e.g. "/").
+func renameTransaction(span *sentry.Span, ctx *fiber.Ctx) {
+	if route := ctx.Route().Path; route != "" {
+		span.Name = route
+		span.Source = sentry.SourceRoute
+	}
+}
+
+// finishTransaction records the outcome of the request on the transaction and finishes it.
+func (h *handler) finishTransaction(span *sentry.Span, ctx *fiber.Ctx, handlerErr error) {
+	status := ctx.Response().StatusCode()
+
+	span.Status = statusToSpanStatus(status)
+	span.SetData("http.method", ctx.Method())
+	span.SetData("http.route", span.Name)
+	span.SetData("http.status_code", status)
+	span.SetData("http.target", string(ctx.Request().URI().Path()))
+	if handlerErr != nil {
+		span.Status = sentry.SpanStatusInternalError
+	}
+
+	span.Finish()
+}
+
+func statusToSpanStatus(status int) sentry.SpanStatus {
+	switch {
+	case status >= 200 && status < 400:
+		return sentry.SpanStatusOK
+	case status == http.StatusNotFound:
+		return sentry.SpanStatusNotFound
+	case status >= 400 && status < 500:
+		return sentry.SpanStatusInvalidArgument
+	case status >= 500:
+		return sentry.SpanStatusInternalError
+	default:
+		return sentry.SpanStatusUndefined
+	}
+}
+
+// GetSpanFromContext retrieves the attached *sentry.Span from the fiber.Ctx, if EnableTracing was set and
+// a transaction was started for the current request.
+func GetSpanFromContext(ctx *fiber.Ctx) *sentry.Span {
+	span := ctx.Locals(spanKey)
+	if span, ok := span.(*sentry.Span); ok {
+		return span
+	}
+
+	return nil
 }
 
 func (h *handler) recoverWithSentry(hub *sentry.Hub, r *http.Request) {
@@ -143,10 +500,21 @@ func isBrokenPipeError(err interface{}) bool {
 
 // GetHubFromContext retrieves attached *sentry.Hub instance from fiber.Ctx.
 func GetHubFromContext(ctx *fiber.Ctx) *sentry.Hub {
-	hub := ctx.Locals(valuesKey)
-	if hub != nil {
-		return hub.(*sentry.Hub)
+	hub, ok := ctx.Locals(valuesKey).(*sentry.Hub)
+	if !ok {
+		return nil
 	}
 
-	return nil
+	return hub
+}
+
+// MustGetHubFromContext retrieves attached *sentry.Hub instance from fiber.Ctx, panicking if sentryfiber.New
+// wasn't installed as a handler on the app or router group.
+func MustGetHubFromContext(ctx *fiber.Ctx) *sentry.Hub {
+	hub := GetHubFromContext(ctx)
+	if hub == nil {
+		panic("sentryfiber: no *sentry.Hub found on fiber.Ctx, is sentryfiber.New() registered as a handler?")
+	}
+
+	return hub
 }
@@ -0,0 +1,159 @@
+package sentryfiber
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStatusToSpanStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   sentry.SpanStatus
+	}{
+		{http.StatusOK, sentry.SpanStatusOK},
+		{http.StatusFound, sentry.SpanStatusOK},
+		{http.StatusNotFound, sentry.SpanStatusNotFound},
+		{http.StatusBadRequest, sentry.SpanStatusInvalidArgument},
+		{http.StatusTeapot, sentry.SpanStatusInvalidArgument},
+		{http.StatusInternalServerError, sentry.SpanStatusInternalError},
+		{http.StatusBadGateway, sentry.SpanStatusInternalError},
+	}
+
+	for _, tt := range tests {
+		if got := statusToSpanStatus(tt.status); got != tt.want {
+			t.Errorf("statusToSpanStatus(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateRequestBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		max  int
+		want string
+	}{
+		{"under limit", []byte("hello"), 10, "hello"},
+		{"exact limit", []byte("hello"), 5, "hello"},
+		{"ascii truncation", []byte("hello world"), 5, "hello"},
+		{"backs off a split multi-byte rune", []byte("h\xe2\x82\xacllo"), 2, "h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(truncateRequestBody(tt.body, tt.max)); got != tt.want {
+				t.Errorf("truncateRequestBody(%q, %d) = %q, want %q", tt.body, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateRequestBodyBinaryPayload(t *testing.T) {
+	// A payload that is not valid UTF-8 throughout (e.g. an image/protobuf/gzip upload) must still come
+	// back close to the requested length, rather than being shrunk to near-nothing by a back-off loop that
+	// revalidates the whole truncated slice as text.
+	body := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0xC0, 0x10, 0x4A, 0x46, 0x46}
+	const max = 6
+
+	got := truncateRequestBody(body, max)
+	if len(got) < max-utf8.UTFMax {
+		t.Errorf("truncateRequestBody trimmed a binary payload from %d to %d bytes, want close to %d", len(body), len(got), max)
+	}
+	if len(got) > max {
+		t.Errorf("truncateRequestBody(%v, %d) returned %d bytes, want at most %d", body, max, len(got), max)
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantHost string
+		wantPort string
+	}{
+		{"192.0.2.1:8080", "192.0.2.1", "8080"},
+		{"[2001:db8::1]:443", "2001:db8::1", "443"},
+		{"no-port-here", "no-port-here", ""},
+	}
+
+	for _, tt := range tests {
+		host, port := splitHostPort(tt.addr)
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("splitHostPort(%q) = (%q, %q), want (%q, %q)", tt.addr, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
+
+func TestFirstForwardedIP(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"203.0.113.1", "203.0.113.1"},
+		{"203.0.113.1, 198.51.100.2", "203.0.113.1"},
+		{"  203.0.113.1  ,198.51.100.2", "203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		if got := firstForwardedIP(tt.header); got != tt.want {
+			t.Errorf("firstForwardedIP(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestIsTrustedProxy(t *testing.T) {
+	h := &handler{trustedProxies: []string{"10.0.0.1", "192.168.1.0/24"}}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.2", false},
+		{"192.168.1.42", true},
+		{"192.168.2.42", false},
+		{"not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		if got := h.isTrustedProxy(tt.ip); got != tt.want {
+			t.Errorf("isTrustedProxy(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if (&handler{}).isTrustedProxy("10.0.0.1") {
+		t.Error("isTrustedProxy with no configured proxies should always be false")
+	}
+}
+
+func TestIgnoredRoutesSkipsHubAttachment(t *testing.T) {
+	app := fiber.New()
+	app.Use(New(Options{IgnoredRoutes: []string{"/health"}}))
+
+	app.Get("/health", func(ctx *fiber.Ctx) error {
+		if GetHubFromContext(ctx) != nil {
+			t.Error("expected no hub to be attached for an ignored route")
+		}
+		return ctx.SendStatus(http.StatusOK)
+	})
+	app.Get("/other", func(ctx *fiber.Ctx) error {
+		if GetHubFromContext(ctx) == nil {
+			t.Error("expected a hub to be attached for a non-ignored route")
+		}
+		return ctx.SendStatus(http.StatusOK)
+	})
+
+	for _, path := range []string{"/health", "/other"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", path, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("request to %s: got status %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+	}
+}